@@ -0,0 +1,135 @@
+package main
+
+import (
+	"context"
+	"io"
+	"math/rand"
+	"net/http"
+	"strconv"
+	"time"
+)
+
+// retryConfig controls the retry/backoff behavior shared by every provider's
+// HTTP calls. It is set once from CLI flags in main().
+var retryConfig = struct {
+	maxRetries int
+}{maxRetries: 3}
+
+func configureRetries(maxRetries int) {
+	retryConfig.maxRetries = maxRetries
+}
+
+var retryableStatus = map[int]bool{
+	http.StatusTooManyRequests:     true,
+	http.StatusInternalServerError: true,
+	http.StatusBadGateway:          true,
+	http.StatusServiceUnavailable:  true,
+	http.StatusGatewayTimeout:      true,
+}
+
+// doHTTPWithRetry executes req, retrying on 429/5xx with exponential
+// backoff and jitter (honoring a Retry-After header when present) up to
+// retryConfig.maxRetries times. The caller must set req's body via
+// GetBody so it can be replayed across attempts.
+func doHTTPWithRetry(ctx context.Context, client *http.Client, newReq func() (*http.Request, error)) (*http.Response, []byte, error) {
+	var lastErr error
+
+	for attempt := 0; attempt <= retryConfig.maxRetries; attempt++ {
+		req, err := newReq()
+		if err != nil {
+			return nil, nil, err
+		}
+		req = req.WithContext(ctx)
+
+		resp, err := client.Do(req)
+		if err != nil {
+			lastErr = err
+			if attempt == retryConfig.maxRetries {
+				break
+			}
+			sleepBackoff(ctx, attempt, 0)
+			continue
+		}
+
+		body, err := io.ReadAll(resp.Body)
+		resp.Body.Close()
+		if err != nil {
+			return nil, nil, err
+		}
+
+		if !retryableStatus[resp.StatusCode] || attempt == retryConfig.maxRetries {
+			return resp, body, nil
+		}
+
+		sleepBackoff(ctx, attempt, retryAfterSeconds(resp))
+	}
+
+	return nil, nil, lastErr
+}
+
+// doStreamingHTTPWithRetry is doHTTPWithRetry for callers that need to read
+// resp.Body themselves (e.g. an SSE stream) rather than have it buffered
+// into memory. On a retryable status it reads and discards the body itself
+// before retrying; on success it returns resp with Body still open — the
+// caller is responsible for closing it.
+func doStreamingHTTPWithRetry(ctx context.Context, client *http.Client, newReq func() (*http.Request, error)) (*http.Response, error) {
+	var lastErr error
+
+	for attempt := 0; attempt <= retryConfig.maxRetries; attempt++ {
+		req, err := newReq()
+		if err != nil {
+			return nil, err
+		}
+		req = req.WithContext(ctx)
+
+		resp, err := client.Do(req)
+		if err != nil {
+			lastErr = err
+			if attempt == retryConfig.maxRetries {
+				break
+			}
+			sleepBackoff(ctx, attempt, 0)
+			continue
+		}
+
+		if !retryableStatus[resp.StatusCode] || attempt == retryConfig.maxRetries {
+			return resp, nil
+		}
+
+		retryAfter := retryAfterSeconds(resp)
+		resp.Body.Close()
+		sleepBackoff(ctx, attempt, retryAfter)
+	}
+
+	return nil, lastErr
+}
+
+// retryAfterSeconds parses a Retry-After header (seconds form), returning 0
+// if absent or unparsable.
+func retryAfterSeconds(resp *http.Response) int {
+	v := resp.Header.Get("Retry-After")
+	if v == "" {
+		return 0
+	}
+	secs, err := strconv.Atoi(v)
+	if err != nil {
+		return 0
+	}
+	return secs
+}
+
+// sleepBackoff waits for max(retryAfter, exponential backoff with jitter)
+// before the next attempt, honoring ctx cancellation.
+func sleepBackoff(ctx context.Context, attempt int, retryAfter int) {
+	backoff := time.Duration(1<<uint(attempt)) * time.Second
+	jitter := time.Duration(rand.Int63n(int64(500 * time.Millisecond)))
+	wait := backoff + jitter
+	if ra := time.Duration(retryAfter) * time.Second; ra > wait {
+		wait = ra
+	}
+
+	select {
+	case <-time.After(wait):
+	case <-ctx.Done():
+	}
+}
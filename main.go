@@ -1,30 +1,69 @@
 package main
 
 import (
-	"bytes"
+	"context"
 	"encoding/json"
 	"flag"
 	"fmt"
 	"io"
-	"net/http"
 	"os"
 	"path/filepath"
 	"strings"
-	"unicode/utf8"
+	"time"
 
 	"gopkg.in/yaml.v2"
 )
 
 type Config struct {
-	APIKey    string `yaml:"api_key"`
-	Model     string `yaml:"model"`
-	MaxTokens int    `yaml:"max_tokens"`
+	APIKey       string                    `yaml:"api_key"`
+	Model        string                    `yaml:"model"`
+	MaxTokens    int                       `yaml:"max_tokens"`
+	Providers    map[string]ProviderConfig `yaml:"providers"`
+	SystemPrompt string                    `yaml:"system_prompt"`
+	ModelPrices  map[string]ModelPrice     `yaml:"model_prices"`
+}
+
+// resolveProvider picks the Provider to use for this invocation: an explicit
+// --provider/LQY_PROVIDER name wins, then the config entry marked
+// `default: true`, then the legacy top-level api_key/model as a bare OpenAI
+// provider for backward compatibility.
+func resolveProvider(config *Config, name string) (Provider, string, error) {
+	if name == "" {
+		name = os.Getenv("LQY_PROVIDER")
+	}
+
+	if name != "" {
+		pc, ok := config.Providers[name]
+		if !ok {
+			return nil, "", fmt.Errorf("unknown provider %q", name)
+		}
+		p, err := newProvider(name, pc)
+		return p, defaultStr(pc.Model, config.Model), err
+	}
+
+	for pname, pc := range config.Providers {
+		if pc.Default {
+			p, err := newProvider(pname, pc)
+			return p, defaultStr(pc.Model, config.Model), err
+		}
+	}
+
+	if len(config.Providers) > 0 {
+		return nil, "", fmt.Errorf("no default provider configured; pass --provider or set providers.<name>.default: true")
+	}
+
+	// No providers: map configured at all, fall back to the legacy
+	// top-level OpenAI config for backward compatibility.
+	p, err := newProvider("openai", ProviderConfig{Type: "openai", APIKey: config.APIKey})
+	return p, config.Model, err
 }
 
 type OpenAIRequest struct {
-	Model     string    `json:"model"`
-	Messages  []Message `json:"messages"`
-	MaxTokens int       `json:"max_tokens"`
+	Model          string          `json:"model"`
+	Messages       []Message       `json:"messages"`
+	MaxTokens      int             `json:"max_tokens"`
+	ResponseFormat json.RawMessage `json:"response_format,omitempty"`
+	Grammar        string          `json:"grammar,omitempty"`
 }
 
 type Message struct {
@@ -38,6 +77,10 @@ type OpenAIResponse struct {
 			Content string `json:"content"`
 		} `json:"message"`
 	} `json:"choices"`
+	Usage struct {
+		PromptTokens     int `json:"prompt_tokens"`
+		CompletionTokens int `json:"completion_tokens"`
+	} `json:"usage"`
 }
 
 const (
@@ -49,18 +92,111 @@ func main() {
 	var yamlOutput bool
 	var jsonOutput bool
 	var maxTokens int
+	var providerName string
+	var streamOutput bool
+	var sessionName string
+	var newSession bool
+	var listSessionsFlag bool
+	var systemPrompt string
+	var showUsage bool
+	var usageReport bool
+	var schemaPath string
+	var schemaRetries int
+	var grammarPath string
+	var timeout time.Duration
+	var maxRetries int
+	var templateName string
 	flag.StringVar(&configFile, "config", filepath.Join(os.Getenv("HOME"), ".lqyconfig.yaml"), "Path to config file")
 	flag.BoolVar(&yamlOutput, "oyaml", false, "Request YAML-structured output from the LLM")
 	flag.BoolVar(&jsonOutput, "ojson", false, "Request JSON-structured output from the LLM")
 	flag.IntVar(&maxTokens, "max-tokens", maxInputTokens, "Maximum number of tokens to use for input")
+	flag.StringVar(&providerName, "provider", "", "Name of the providers: entry to use (defaults to LQY_PROVIDER env or the configured default)")
+	flag.BoolVar(&streamOutput, "stream", false, "Stream the response to stdout incrementally as it arrives")
+	flag.StringVar(&sessionName, "session", "", "Name of a conversation session to load and append to")
+	flag.BoolVar(&newSession, "new-session", false, "Start the named session over instead of appending to it")
+	flag.BoolVar(&listSessionsFlag, "list-sessions", false, "List saved session names and exit")
+	flag.StringVar(&systemPrompt, "system", "", "System prompt to prepend to the conversation (overrides system_prompt:)")
+	flag.BoolVar(&showUsage, "show-usage", false, "Print a token/cost usage summary to stderr after the response")
+	flag.BoolVar(&usageReport, "usage-report", false, "Print accumulated spend by model/day from ~/.lqy/usage.jsonl and exit")
+	flag.StringVar(&schemaPath, "schema", "", "Path to a JSON Schema to constrain and validate -ojson output against")
+	flag.IntVar(&schemaRetries, "schema-retries", 2, "Number of times to retry the request after a schema validation failure")
+	flag.StringVar(&grammarPath, "grammar", "", "Path to a GBNF grammar forwarded to LocalAI-style backends")
+	flag.DurationVar(&timeout, "timeout", 60*time.Second, "Timeout for the LLM request")
+	flag.IntVar(&maxRetries, "max-retries", 3, "Maximum number of retries on 429/5xx responses")
+	flag.StringVar(&templateName, "t", "", "Name of a prompt template (from ~/.lqy/templates or a built-in) to render")
 	flag.Parse()
 
+	configureRetries(maxRetries)
+
+	if listSessionsFlag {
+		if err := printSessions(); err != nil {
+			fmt.Fprintf(os.Stderr, "Error listing sessions: %v\n", err)
+			os.Exit(1)
+		}
+		return
+	}
+
+	if usageReport {
+		if err := printUsageReport(); err != nil {
+			fmt.Fprintf(os.Stderr, "Error reading usage report: %v\n", err)
+			os.Exit(1)
+		}
+		return
+	}
+
 	config, err := loadConfig(configFile)
 	if err != nil {
 		fmt.Fprintf(os.Stderr, "Error loading config: %v\n", err)
 		os.Exit(1)
 	}
 
+	provider, model, err := resolveProvider(config, providerName)
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "Error resolving provider: %v\n", err)
+		os.Exit(1)
+	}
+
+	var session *Session
+	if sessionName != "" {
+		if newSession {
+			session = &Session{Name: sessionName}
+		} else {
+			session, err = loadSession(sessionName)
+			if err != nil {
+				fmt.Fprintf(os.Stderr, "Error loading session: %v\n", err)
+				os.Exit(1)
+			}
+		}
+	}
+
+	if systemPrompt == "" {
+		systemPrompt = config.SystemPrompt
+	}
+
+	var validator *SchemaValidator
+	var responseFormat json.RawMessage
+	if schemaPath != "" {
+		validator, err = loadSchema(schemaPath)
+		if err != nil {
+			fmt.Fprintf(os.Stderr, "Error loading schema: %v\n", err)
+			os.Exit(1)
+		}
+		responseFormat, err = validator.responseFormat()
+		if err != nil {
+			fmt.Fprintf(os.Stderr, "Error building response_format: %v\n", err)
+			os.Exit(1)
+		}
+	}
+
+	var grammar string
+	if grammarPath != "" {
+		grammar, err = loadGrammar(grammarPath)
+		if err != nil {
+			fmt.Fprintf(os.Stderr, "Error loading grammar: %v\n", err)
+			os.Exit(1)
+		}
+	}
+
 	var input string
 	var stdinInput string
 
@@ -71,20 +207,38 @@ func main() {
 		stdinInput = string(stdinBytes)
 	}
 
-	// Get input from command-line arguments
-	argInput := strings.Join(flag.Args(), " ")
-
-	// Combine stdin and argument inputs
-	if stdinInput != "" && argInput != "" {
-		input = fmt.Sprintf("Question: %s\n\nContext:\n%s", argInput, stdinInput)
-	} else if stdinInput != "" {
-		input = stdinInput
-	} else if argInput != "" {
-		input = argInput
+	if templateName != "" {
+		tmpl, err := loadTemplate(templateName)
+		if err != nil {
+			fmt.Fprintf(os.Stderr, "Error loading template: %v\n", err)
+			os.Exit(1)
+		}
+		values := templateValues(tmpl, flag.Args(), stdinInput)
+		renderedSystem, renderedUser, err := tmpl.render(values)
+		if err != nil {
+			fmt.Fprintf(os.Stderr, "Error rendering template: %v\n", err)
+			os.Exit(1)
+		}
+		if systemPrompt == "" {
+			systemPrompt = renderedSystem
+		}
+		input = renderedUser
 	} else {
-		fmt.Println("Usage: lqy [--config <filepath>] [-ojson|-oyaml] [--max-tokens <number>] <input>")
-		fmt.Println("   or: <command> | lqy [-ojson|-oyaml] [--max-tokens <number>] <question>")
-		os.Exit(1)
+		// Get input from command-line arguments
+		argInput := strings.Join(flag.Args(), " ")
+
+		// Combine stdin and argument inputs
+		if stdinInput != "" && argInput != "" {
+			input = fmt.Sprintf("Question: %s\n\nContext:\n%s", argInput, stdinInput)
+		} else if stdinInput != "" {
+			input = stdinInput
+		} else if argInput != "" {
+			input = argInput
+		} else {
+			fmt.Println("Usage: lqy [--config <filepath>] [-ojson|-oyaml] [--max-tokens <number>] <input>")
+			fmt.Println("   or: <command> | lqy [-ojson|-oyaml] [--max-tokens <number>] <question>")
+			os.Exit(1)
+		}
 	}
 
 	// Append JSON instruction if -ojson flag is set
@@ -104,15 +258,100 @@ func main() {
   }
 
 	// Truncate input if it exceeds the token limit
-	input = truncateInput(input, maxTokens)
-
-	response, err := generateLLMResponse(config, input)
+	input, err = truncateInput(input, model, maxTokens)
 	if err != nil {
-		fmt.Fprintf(os.Stderr, "Error generating response: %v\n", err)
+		fmt.Fprintf(os.Stderr, "Error tokenizing input: %v\n", err)
 		os.Exit(1)
 	}
 
-	fmt.Print(response)
+	var messages []Message
+	if systemPrompt != "" {
+		messages = append(messages, Message{Role: "system", Content: systemPrompt})
+	}
+	if session != nil {
+		messages = append(messages, session.Messages...)
+	}
+	messages = append(messages, Message{Role: "user", Content: input})
+
+	req := Request{
+		Model:          model,
+		Messages:       messages,
+		MaxTokens:      config.MaxTokens,
+		ResponseFormat: responseFormat,
+		Grammar:        grammar,
+	}
+
+	var assistantReply string
+
+	ctx, cancel := context.WithTimeout(context.Background(), timeout)
+	defer cancel()
+
+	// Structured output must be parsed as a whole, so -ojson/-oyaml and
+	// --schema (which validates and retries against the full response)
+	// always buffer even if --stream was requested.
+	if streamOutput && !jsonOutput && !yamlOutput && schemaPath == "" {
+		var buf strings.Builder
+		err = provider.Stream(ctx, req, func(delta string) {
+			fmt.Print(delta)
+			buf.WriteString(delta)
+		})
+		if err != nil {
+			fmt.Fprintf(os.Stderr, "Error generating response: %v\n", err)
+			os.Exit(1)
+		}
+		assistantReply = buf.String()
+	} else {
+		var response Response
+		attempt := 0
+		for {
+			response, err = provider.Complete(ctx, req)
+			if err != nil {
+				fmt.Fprintf(os.Stderr, "Error generating response: %v\n", err)
+				os.Exit(1)
+			}
+
+			if validator == nil {
+				break
+			}
+			if validateErr := validator.Validate(response.Content); validateErr == nil {
+				break
+			} else if attempt >= schemaRetries {
+				fmt.Fprintf(os.Stderr, "Error: response failed schema validation after %d retries: %v\n", schemaRetries, validateErr)
+				os.Exit(1)
+			} else {
+				req.Messages = append(req.Messages,
+					Message{Role: "assistant", Content: response.Content},
+					Message{Role: "user", Content: fmt.Sprintf("your previous reply failed schema validation: %v\n\nPlease reply again with JSON that satisfies the schema.", validateErr)})
+				attempt++
+			}
+		}
+		assistantReply = response.Content
+		fmt.Print(assistantReply)
+
+		cost := costFor(model, config.ModelPrices, response.Usage)
+		if showUsage {
+			showUsageSummary(model, response.Usage, cost)
+		}
+		if response.Usage.PromptTokens > 0 || response.Usage.CompletionTokens > 0 {
+			if err := recordUsage(UsageRecord{
+				Timestamp:        time.Now(),
+				Model:            model,
+				PromptTokens:     response.Usage.PromptTokens,
+				CompletionTokens: response.Usage.CompletionTokens,
+				CostUSD:          cost,
+			}); err != nil {
+				fmt.Fprintf(os.Stderr, "Error recording usage: %v\n", err)
+			}
+		}
+	}
+
+	if session != nil {
+		session.Messages = append(session.Messages, Message{Role: "user", Content: input}, Message{Role: "assistant", Content: assistantReply})
+		if err := session.save(); err != nil {
+			fmt.Fprintf(os.Stderr, "Error saving session: %v\n", err)
+			os.Exit(1)
+		}
+	}
 }
 
 func loadConfig(filepath string) (*Config, error) {
@@ -130,71 +369,3 @@ func loadConfig(filepath string) (*Config, error) {
 	return &config, nil
 }
 
-func generateLLMResponse(config *Config, input string) (string, error) {
-	url := "https://api.openai.com/v1/chat/completions"
-
-	requestBody := OpenAIRequest{
-		Model: config.Model,
-		Messages: []Message{
-			{
-				Role:    "user",
-				Content: input,
-			},
-		},
-		MaxTokens: config.MaxTokens,
-	}
-
-	jsonBody, err := json.Marshal(requestBody)
-	if err != nil {
-		return "", err
-	}
-
-	req, err := http.NewRequest("POST", url, bytes.NewBuffer(jsonBody))
-	if err != nil {
-		return "", err
-	}
-
-	req.Header.Set("Content-Type", "application/json")
-	req.Header.Set("Authorization", "Bearer "+config.APIKey)
-
-	client := &http.Client{}
-	resp, err := client.Do(req)
-	if err != nil {
-		return "", err
-	}
-	defer resp.Body.Close()
-
-	body, err := io.ReadAll(resp.Body)
-	if err != nil {
-		return "", err
-	}
-
-	if resp.StatusCode != http.StatusOK {
-		return "", fmt.Errorf("API request failed with status code %d: %s", resp.StatusCode, string(body))
-	}
-
-	var openAIResp OpenAIResponse
-	err = json.Unmarshal(body, &openAIResp)
-	if err != nil {
-		return "", err
-	}
-
-	if len(openAIResp.Choices) == 0 {
-		return "", fmt.Errorf("no response choices returned")
-	}
-
-	return openAIResp.Choices[0].Message.Content, nil
-}
-
-func truncateInput(input string, maxTokens int) string {
-	// This is a very rough approximation. In reality, tokenization is more complex.
-	// We're using 4 characters as an approximate average token length.
-	maxChars := maxTokens * 4
-
-	if utf8.RuneCountInString(input) <= maxChars {
-		return input
-	}
-
-	truncated := []rune(input)[:maxChars]
-	return string(truncated) + "\n...(input truncated due to length)"
-}
@@ -0,0 +1,500 @@
+package main
+
+import (
+	"bufio"
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"strings"
+)
+
+// Request is the provider-agnostic shape of a single completion call.
+type Request struct {
+	Model     string
+	Messages  []Message
+	MaxTokens int
+	Stream    bool
+
+	// ResponseFormat is forwarded verbatim as `response_format` to
+	// providers that support schema-constrained decoding (currently
+	// OpenAI).
+	ResponseFormat json.RawMessage
+	// Grammar is a GBNF grammar forwarded as `grammar` to LocalAI-style
+	// backends.
+	Grammar string
+}
+
+// Response is the provider-agnostic result of a completion call.
+type Response struct {
+	Content string
+	Usage   Usage
+}
+
+// Usage reports token accounting for a single completion call, when the
+// provider's API exposes it.
+type Usage struct {
+	PromptTokens     int
+	CompletionTokens int
+}
+
+// Provider talks to a single LLM backend, translating Request/Response into
+// that backend's native wire format.
+type Provider interface {
+	// Complete sends req and returns the full response.
+	Complete(ctx context.Context, req Request) (Response, error)
+	// Stream sends req and invokes onDelta for each incremental chunk of
+	// content as it arrives.
+	Stream(ctx context.Context, req Request, onDelta func(string)) error
+}
+
+// ProviderConfig describes one entry under the `providers:` config map.
+type ProviderConfig struct {
+	Type      string           `yaml:"type"`
+	APIKey    string           `yaml:"api_key"`
+	BaseURL   string           `yaml:"base_url"`
+	Model     string           `yaml:"model"`
+	Default   bool             `yaml:"default"`
+	RateLimit *RateLimitConfig `yaml:"rate_limit"`
+}
+
+// newProvider builds the concrete Provider for a ProviderConfig, wrapping it
+// with a rate limiter when rate_limit: is set.
+func newProvider(name string, pc ProviderConfig) (Provider, error) {
+	p, err := newBaseProvider(name, pc)
+	if err != nil || pc.RateLimit == nil {
+		return p, err
+	}
+	return &rateLimitedProvider{Provider: p, limiter: newRateLimiter(pc.RateLimit)}, nil
+}
+
+func newBaseProvider(name string, pc ProviderConfig) (Provider, error) {
+	switch strings.ToLower(pc.Type) {
+	case "openai":
+		return &openAIProvider{apiKey: pc.APIKey, baseURL: defaultStr(pc.BaseURL, "https://api.openai.com/v1")}, nil
+	case "anthropic":
+		return &anthropicProvider{apiKey: pc.APIKey, baseURL: defaultStr(pc.BaseURL, "https://api.anthropic.com")}, nil
+	case "mistral":
+		return &mistralProvider{apiKey: pc.APIKey, baseURL: defaultStr(pc.BaseURL, "https://api.mistral.ai/v1")}, nil
+	case "localai":
+		if pc.BaseURL == "" {
+			return nil, fmt.Errorf("provider %q: localai requires base_url", name)
+		}
+		return &localAIProvider{apiKey: pc.APIKey, baseURL: pc.BaseURL}, nil
+	case "gemini":
+		return &geminiProvider{apiKey: pc.APIKey, baseURL: defaultStr(pc.BaseURL, "https://generativelanguage.googleapis.com/v1beta")}, nil
+	case "zhipu":
+		return &zhipuProvider{apiKey: pc.APIKey, baseURL: defaultStr(pc.BaseURL, "https://open.bigmodel.cn/api/paas/v4")}, nil
+	default:
+		return nil, fmt.Errorf("provider %q: unknown type %q", name, pc.Type)
+	}
+}
+
+// rateLimitedProvider wraps a Provider with a token-bucket limiter checked
+// before every call.
+type rateLimitedProvider struct {
+	Provider
+	limiter *rateLimiter
+}
+
+func (p *rateLimitedProvider) estimatedTokens(req Request) int {
+	total := 0
+	for _, m := range req.Messages {
+		n, err := countTokens(m.Content, req.Model)
+		if err != nil {
+			n = len(m.Content) / 4
+		}
+		total += n
+	}
+	return total
+}
+
+func (p *rateLimitedProvider) Complete(ctx context.Context, req Request) (Response, error) {
+	if err := p.limiter.allow(p.estimatedTokens(req)); err != nil {
+		return Response{}, err
+	}
+	return p.Provider.Complete(ctx, req)
+}
+
+func (p *rateLimitedProvider) Stream(ctx context.Context, req Request, onDelta func(string)) error {
+	if err := p.limiter.allow(p.estimatedTokens(req)); err != nil {
+		return err
+	}
+	return p.Provider.Stream(ctx, req, onDelta)
+}
+
+func defaultStr(v, fallback string) string {
+	if v == "" {
+		return fallback
+	}
+	return v
+}
+
+// splitSystemMessage lifts the first `role: "system"` message out of
+// messages for providers (Anthropic, Gemini) whose native API takes the
+// system prompt as a separate field rather than as a message with that
+// role.
+func splitSystemMessage(messages []Message) (system string, rest []Message) {
+	rest = make([]Message, 0, len(messages))
+	for _, m := range messages {
+		if m.Role == "system" && system == "" {
+			system = m.Content
+			continue
+		}
+		rest = append(rest, m)
+	}
+	return system, rest
+}
+
+// doJSON posts body to url with the given headers and decodes the JSON
+// response into out, returning an error on non-2xx status. It retries on
+// 429/5xx responses per retryConfig.
+func doJSON(ctx context.Context, url string, headers map[string]string, body interface{}, out interface{}) error {
+	jsonBody, err := json.Marshal(body)
+	if err != nil {
+		return err
+	}
+
+	client := &http.Client{}
+	resp, respBody, err := doHTTPWithRetry(ctx, client, func() (*http.Request, error) {
+		req, err := http.NewRequest("POST", url, bytes.NewBuffer(jsonBody))
+		if err != nil {
+			return nil, err
+		}
+		req.Header.Set("Content-Type", "application/json")
+		for k, v := range headers {
+			req.Header.Set(k, v)
+		}
+		return req, nil
+	})
+	if err != nil {
+		return err
+	}
+
+	if resp.StatusCode != http.StatusOK {
+		return fmt.Errorf("API request failed with status code %d: %s", resp.StatusCode, string(respBody))
+	}
+
+	return json.Unmarshal(respBody, out)
+}
+
+// --- OpenAI ---
+
+type openAIProvider struct {
+	apiKey  string
+	baseURL string
+}
+
+func (p *openAIProvider) Complete(ctx context.Context, req Request) (Response, error) {
+	var resp OpenAIResponse
+	err := doJSON(ctx, p.baseURL+"/chat/completions",
+		map[string]string{"Authorization": "Bearer " + p.apiKey},
+		OpenAIRequest{Model: req.Model, Messages: req.Messages, MaxTokens: req.MaxTokens, ResponseFormat: req.ResponseFormat},
+		&resp)
+	if err != nil {
+		return Response{}, err
+	}
+	if len(resp.Choices) == 0 {
+		return Response{}, fmt.Errorf("no response choices returned")
+	}
+	return Response{Content: resp.Choices[0].Message.Content, Usage: Usage{PromptTokens: resp.Usage.PromptTokens, CompletionTokens: resp.Usage.CompletionTokens}}, nil
+}
+
+// openAIStreamDelta mirrors a single `data: {...}` chunk of an OpenAI
+// chat-completions SSE stream.
+type openAIStreamDelta struct {
+	Choices []struct {
+		Delta struct {
+			Content string `json:"content"`
+		} `json:"delta"`
+	} `json:"choices"`
+}
+
+func (p *openAIProvider) Stream(ctx context.Context, req Request, onDelta func(string)) error {
+	return streamOpenAICompatible(ctx, p.baseURL+"/chat/completions",
+		map[string]string{"Authorization": "Bearer " + p.apiKey}, req, onDelta)
+}
+
+// streamOpenAICompatible issues a streaming chat-completions request and
+// feeds each `choices[0].delta.content` chunk to onDelta as it arrives. It is
+// shared by every provider that speaks the OpenAI-compatible SSE dialect.
+func streamOpenAICompatible(ctx context.Context, url string, headers map[string]string, req Request, onDelta func(string)) error {
+	body := OpenAIRequest{Model: req.Model, Messages: req.Messages, MaxTokens: req.MaxTokens}
+	jsonBody, err := json.Marshal(struct {
+		OpenAIRequest
+		Stream bool `json:"stream"`
+	}{body, true})
+	if err != nil {
+		return err
+	}
+
+	client := &http.Client{}
+	resp, err := doStreamingHTTPWithRetry(ctx, client, func() (*http.Request, error) {
+		httpReq, err := http.NewRequest("POST", url, bytes.NewBuffer(jsonBody))
+		if err != nil {
+			return nil, err
+		}
+		httpReq.Header.Set("Content-Type", "application/json")
+		httpReq.Header.Set("Accept", "text/event-stream")
+		for k, v := range headers {
+			httpReq.Header.Set(k, v)
+		}
+		return httpReq, nil
+	})
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode != http.StatusOK {
+		respBody, _ := io.ReadAll(resp.Body)
+		return fmt.Errorf("API request failed with status code %d: %s", resp.StatusCode, string(respBody))
+	}
+
+	scanner := bufio.NewScanner(resp.Body)
+	scanner.Buffer(make([]byte, 0, 64*1024), 1024*1024)
+	for scanner.Scan() {
+		line := scanner.Text()
+		if !strings.HasPrefix(line, "data: ") {
+			continue
+		}
+		data := strings.TrimPrefix(line, "data: ")
+		if data == "[DONE]" {
+			return nil
+		}
+
+		var delta openAIStreamDelta
+		if err := json.Unmarshal([]byte(data), &delta); err != nil {
+			return fmt.Errorf("parsing stream chunk: %w", err)
+		}
+		if len(delta.Choices) > 0 {
+			onDelta(delta.Choices[0].Delta.Content)
+		}
+	}
+	return scanner.Err()
+}
+
+// --- Anthropic ---
+
+type anthropicMessagesRequest struct {
+	Model     string    `json:"model"`
+	Messages  []Message `json:"messages"`
+	MaxTokens int       `json:"max_tokens"`
+	System    string    `json:"system,omitempty"`
+}
+
+type anthropicMessagesResponse struct {
+	Content []struct {
+		Text string `json:"text"`
+	} `json:"content"`
+	Usage struct {
+		InputTokens  int `json:"input_tokens"`
+		OutputTokens int `json:"output_tokens"`
+	} `json:"usage"`
+}
+
+type anthropicProvider struct {
+	apiKey  string
+	baseURL string
+}
+
+func (p *anthropicProvider) Complete(ctx context.Context, req Request) (Response, error) {
+	system, messages := splitSystemMessage(req.Messages)
+
+	var resp anthropicMessagesResponse
+	err := doJSON(ctx, p.baseURL+"/v1/messages",
+		map[string]string{
+			"x-api-key":         p.apiKey,
+			"anthropic-version": "2023-06-01",
+		},
+		anthropicMessagesRequest{Model: req.Model, Messages: messages, MaxTokens: req.MaxTokens, System: system},
+		&resp)
+	if err != nil {
+		return Response{}, err
+	}
+	if len(resp.Content) == 0 {
+		return Response{}, fmt.Errorf("no response content returned")
+	}
+	return Response{
+		Content: resp.Content[0].Text,
+		Usage:   Usage{PromptTokens: resp.Usage.InputTokens, CompletionTokens: resp.Usage.OutputTokens},
+	}, nil
+}
+
+func (p *anthropicProvider) Stream(ctx context.Context, req Request, onDelta func(string)) error {
+	return fmt.Errorf("streaming not yet implemented for anthropic provider")
+}
+
+// --- Mistral (OpenAI-compatible) ---
+
+type mistralProvider struct {
+	apiKey  string
+	baseURL string
+}
+
+func (p *mistralProvider) Complete(ctx context.Context, req Request) (Response, error) {
+	var resp OpenAIResponse
+	err := doJSON(ctx, p.baseURL+"/chat/completions",
+		map[string]string{"Authorization": "Bearer " + p.apiKey},
+		OpenAIRequest{Model: req.Model, Messages: req.Messages, MaxTokens: req.MaxTokens},
+		&resp)
+	if err != nil {
+		return Response{}, err
+	}
+	if len(resp.Choices) == 0 {
+		return Response{}, fmt.Errorf("no response choices returned")
+	}
+	return Response{Content: resp.Choices[0].Message.Content, Usage: Usage{PromptTokens: resp.Usage.PromptTokens, CompletionTokens: resp.Usage.CompletionTokens}}, nil
+}
+
+func (p *mistralProvider) Stream(ctx context.Context, req Request, onDelta func(string)) error {
+	return streamOpenAICompatible(ctx, p.baseURL+"/chat/completions",
+		map[string]string{"Authorization": "Bearer " + p.apiKey}, req, onDelta)
+}
+
+// --- LocalAI (OpenAI-compatible, user-supplied base URL) ---
+
+type localAIProvider struct {
+	apiKey  string
+	baseURL string
+}
+
+func (p *localAIProvider) Complete(ctx context.Context, req Request) (Response, error) {
+	headers := map[string]string{}
+	if p.apiKey != "" {
+		headers["Authorization"] = "Bearer " + p.apiKey
+	}
+	var resp OpenAIResponse
+	err := doJSON(ctx, p.baseURL+"/chat/completions", headers,
+		OpenAIRequest{Model: req.Model, Messages: req.Messages, MaxTokens: req.MaxTokens, Grammar: req.Grammar},
+		&resp)
+	if err != nil {
+		return Response{}, err
+	}
+	if len(resp.Choices) == 0 {
+		return Response{}, fmt.Errorf("no response choices returned")
+	}
+	return Response{Content: resp.Choices[0].Message.Content, Usage: Usage{PromptTokens: resp.Usage.PromptTokens, CompletionTokens: resp.Usage.CompletionTokens}}, nil
+}
+
+func (p *localAIProvider) Stream(ctx context.Context, req Request, onDelta func(string)) error {
+	headers := map[string]string{}
+	if p.apiKey != "" {
+		headers["Authorization"] = "Bearer " + p.apiKey
+	}
+	return streamOpenAICompatible(ctx, p.baseURL+"/chat/completions", headers, req, onDelta)
+}
+
+// --- Google Gemini ---
+
+type geminiPart struct {
+	Text string `json:"text"`
+}
+
+type geminiContent struct {
+	Parts []geminiPart `json:"parts"`
+	Role  string       `json:"role,omitempty"`
+}
+
+type geminiRequest struct {
+	Contents          []geminiContent `json:"contents"`
+	SystemInstruction *geminiContent  `json:"systemInstruction,omitempty"`
+}
+
+type geminiResponse struct {
+	Candidates []struct {
+		Content geminiContent `json:"content"`
+	} `json:"candidates"`
+	UsageMetadata struct {
+		PromptTokenCount     int `json:"promptTokenCount"`
+		CandidatesTokenCount int `json:"candidatesTokenCount"`
+	} `json:"usageMetadata"`
+}
+
+type geminiProvider struct {
+	apiKey  string
+	baseURL string
+}
+
+func (p *geminiProvider) Complete(ctx context.Context, req Request) (Response, error) {
+	system, messages := splitSystemMessage(req.Messages)
+
+	contents := make([]geminiContent, 0, len(messages))
+	for _, m := range messages {
+		role := m.Role
+		if role == "assistant" {
+			role = "model"
+		}
+		contents = append(contents, geminiContent{
+			Role:  role,
+			Parts: []geminiPart{{Text: m.Content}},
+		})
+	}
+
+	geminiReq := geminiRequest{Contents: contents}
+	if system != "" {
+		geminiReq.SystemInstruction = &geminiContent{Parts: []geminiPart{{Text: system}}}
+	}
+
+	url := fmt.Sprintf("%s/models/%s:generateContent?key=%s", p.baseURL, req.Model, p.apiKey)
+
+	var resp geminiResponse
+	err := doJSON(ctx, url, nil, geminiReq, &resp)
+	if err != nil {
+		return Response{}, err
+	}
+	if len(resp.Candidates) == 0 || len(resp.Candidates[0].Content.Parts) == 0 {
+		return Response{}, fmt.Errorf("no response candidates returned")
+	}
+	return Response{
+		Content: resp.Candidates[0].Content.Parts[0].Text,
+		Usage:   Usage{PromptTokens: resp.UsageMetadata.PromptTokenCount, CompletionTokens: resp.UsageMetadata.CandidatesTokenCount},
+	}, nil
+}
+
+func (p *geminiProvider) Stream(ctx context.Context, req Request, onDelta func(string)) error {
+	return fmt.Errorf("streaming not yet implemented for gemini provider")
+}
+
+// --- Zhipu GLM-4 ---
+
+type zhipuProvider struct {
+	apiKey  string
+	baseURL string
+}
+
+func (p *zhipuProvider) Complete(ctx context.Context, req Request) (Response, error) {
+	token, err := zhipuJWT(p.apiKey)
+	if err != nil {
+		return Response{}, err
+	}
+
+	var resp OpenAIResponse
+	err = doJSON(ctx, p.baseURL+"/chat/completions",
+		map[string]string{"Authorization": "Bearer " + token},
+		OpenAIRequest{Model: req.Model, Messages: req.Messages, MaxTokens: req.MaxTokens},
+		&resp)
+	if err != nil {
+		return Response{}, err
+	}
+	if len(resp.Choices) == 0 {
+		return Response{}, fmt.Errorf("no response choices returned")
+	}
+	return Response{Content: resp.Choices[0].Message.Content, Usage: Usage{PromptTokens: resp.Usage.PromptTokens, CompletionTokens: resp.Usage.CompletionTokens}}, nil
+}
+
+func (p *zhipuProvider) Stream(ctx context.Context, req Request, onDelta func(string)) error {
+	return fmt.Errorf("streaming not yet implemented for zhipu provider")
+}
+
+// zhipuJWT builds the short-lived HMAC-SHA256 JWT that Zhipu's API expects
+// in place of a bearer token. The api key is formatted "id.secret".
+func zhipuJWT(apiKey string) (string, error) {
+	parts := strings.SplitN(apiKey, ".", 2)
+	if len(parts) != 2 {
+		return "", fmt.Errorf("zhipu api_key must be in the form \"id.secret\"")
+	}
+	return signZhipuToken(parts[0], parts[1])
+}
@@ -0,0 +1,113 @@
+package main
+
+import (
+	"fmt"
+	"sync"
+	"time"
+)
+
+// RateLimitConfig configures a per-provider token-bucket limiter, checked
+// before every completion call so scripted pipelines don't blow through a
+// provider's quota.
+type RateLimitConfig struct {
+	RPM int `yaml:"rpm"`
+	TPM int `yaml:"tpm"`
+}
+
+// RateLimitError is returned when a request would exceed the configured
+// rate limit, so callers can display it distinctly from a transport error.
+type RateLimitError struct {
+	Limit string // "rpm" or "tpm"
+}
+
+func (e *RateLimitError) Error() string {
+	return fmt.Sprintf("rate limit exceeded (%s)", e.Limit)
+}
+
+// tokenBucket is a simple refilling token bucket: it holds up to `capacity`
+// tokens and refills at `capacity` per minute.
+type tokenBucket struct {
+	mu       sync.Mutex
+	capacity float64
+	tokens   float64
+	last     time.Time
+}
+
+func newTokenBucket(capacity int) *tokenBucket {
+	return &tokenBucket{capacity: float64(capacity), tokens: float64(capacity), last: time.Now()}
+}
+
+// take reports whether n tokens are available, consuming them if so.
+func (b *tokenBucket) take(n float64) bool {
+	if b == nil || b.capacity <= 0 {
+		return true
+	}
+
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	now := time.Now()
+	elapsed := now.Sub(b.last).Minutes()
+	b.tokens += elapsed * b.capacity
+	if b.tokens > b.capacity {
+		b.tokens = b.capacity
+	}
+	b.last = now
+
+	if b.tokens < n {
+		return false
+	}
+	b.tokens -= n
+	return true
+}
+
+// refund returns n tokens to the bucket, capped at capacity. Used to undo
+// a take() when a later check in the same request fails.
+func (b *tokenBucket) refund(n float64) {
+	if b == nil || b.capacity <= 0 {
+		return
+	}
+
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	b.tokens += n
+	if b.tokens > b.capacity {
+		b.tokens = b.capacity
+	}
+}
+
+// rateLimiter enforces a provider's requests-per-minute and
+// tokens-per-minute limits.
+type rateLimiter struct {
+	requests *tokenBucket
+	tokens   *tokenBucket
+}
+
+func newRateLimiter(cfg *RateLimitConfig) *rateLimiter {
+	if cfg == nil {
+		return nil
+	}
+	return &rateLimiter{
+		requests: newTokenBucket(cfg.RPM),
+		tokens:   newTokenBucket(cfg.TPM),
+	}
+}
+
+// allow checks the request against both buckets, estimating token spend
+// from the length of the outgoing messages. If the token bucket rejects
+// the request, the request-bucket token is refunded so a TPM-limited
+// script doesn't also burn through its RPM budget on every rejected call.
+func (l *rateLimiter) allow(estimatedTokens int) error {
+	if l == nil {
+		return nil
+	}
+	if !l.requests.take(1) {
+		return &RateLimitError{Limit: "rpm"}
+	}
+	if !l.tokens.take(float64(estimatedTokens)) {
+		l.requests.refund(1)
+		return &RateLimitError{Limit: "tpm"}
+	}
+	return nil
+}
@@ -0,0 +1,76 @@
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"os"
+
+	"github.com/santhosh-tekuri/jsonschema/v5"
+)
+
+// SchemaValidator enforces a JSON Schema against an LLM's structured output,
+// and builds the OpenAI `response_format` payload that asks the model to
+// follow it directly.
+type SchemaValidator struct {
+	schema *jsonschema.Schema
+	raw    json.RawMessage
+}
+
+// loadSchema compiles the JSON Schema file at path.
+func loadSchema(path string) (*SchemaValidator, error) {
+	raw, err := os.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+
+	compiler := jsonschema.NewCompiler()
+	if err := compiler.AddResource(path, bytes.NewReader(raw)); err != nil {
+		return nil, err
+	}
+	schema, err := compiler.Compile(path)
+	if err != nil {
+		return nil, err
+	}
+
+	return &SchemaValidator{schema: schema, raw: raw}, nil
+}
+
+// Validate parses content as JSON and checks it against the schema.
+func (v *SchemaValidator) Validate(content string) error {
+	var data interface{}
+	if err := json.Unmarshal([]byte(content), &data); err != nil {
+		return fmt.Errorf("response is not valid JSON: %w", err)
+	}
+	return v.schema.Validate(data)
+}
+
+// responseFormat builds the `response_format: {"type":"json_schema",...}`
+// payload OpenAI expects for schema-constrained decoding.
+func (v *SchemaValidator) responseFormat() (json.RawMessage, error) {
+	return json.Marshal(struct {
+		Type       string `json:"type"`
+		JSONSchema struct {
+			Name   string          `json:"name"`
+			Schema json.RawMessage `json:"schema"`
+			Strict bool            `json:"strict"`
+		} `json:"json_schema"`
+	}{
+		Type: "json_schema",
+		JSONSchema: struct {
+			Name   string          `json:"name"`
+			Schema json.RawMessage `json:"schema"`
+			Strict bool            `json:"strict"`
+		}{Name: "response", Schema: v.raw, Strict: true},
+	})
+}
+
+// loadGrammar reads a GBNF grammar file for pass-through to LocalAI-style
+// backends via their `grammar` field.
+func loadGrammar(path string) (string, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return "", err
+	}
+	return string(data), nil
+}
@@ -0,0 +1,117 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"os"
+	"path/filepath"
+	"sort"
+)
+
+// Session is the on-disk representation of a conversation's history,
+// persisted as ~/.lqy/sessions/<name>.json.
+type Session struct {
+	Name     string    `json:"name"`
+	Messages []Message `json:"messages"`
+}
+
+// sessionsDir returns ~/.lqy/sessions, creating it if necessary.
+func sessionsDir() (string, error) {
+	dir := filepath.Join(os.Getenv("HOME"), ".lqy", "sessions")
+	if err := os.MkdirAll(dir, 0o755); err != nil {
+		return "", err
+	}
+	return dir, nil
+}
+
+func sessionPath(name string) (string, error) {
+	if name != filepath.Base(name) {
+		return "", fmt.Errorf("invalid session name %q", name)
+	}
+
+	dir, err := sessionsDir()
+	if err != nil {
+		return "", err
+	}
+	return filepath.Join(dir, name+".json"), nil
+}
+
+// loadSession reads the named session from disk, returning an empty session
+// if it doesn't exist yet (e.g. the first turn, or --new-session).
+func loadSession(name string) (*Session, error) {
+	path, err := sessionPath(name)
+	if err != nil {
+		return nil, err
+	}
+
+	data, err := os.ReadFile(path)
+	if os.IsNotExist(err) {
+		return &Session{Name: name}, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	var s Session
+	if err := json.Unmarshal(data, &s); err != nil {
+		return nil, err
+	}
+	return &s, nil
+}
+
+// save persists the session to ~/.lqy/sessions/<name>.json.
+func (s *Session) save() error {
+	path, err := sessionPath(s.Name)
+	if err != nil {
+		return err
+	}
+
+	data, err := json.MarshalIndent(s, "", "  ")
+	if err != nil {
+		return err
+	}
+	return os.WriteFile(path, data, 0o644)
+}
+
+// listSessions returns the names of every saved session, sorted
+// alphabetically.
+func listSessions() ([]string, error) {
+	dir, err := sessionsDir()
+	if err != nil {
+		return nil, err
+	}
+
+	entries, err := os.ReadDir(dir)
+	if err != nil {
+		return nil, err
+	}
+
+	names := make([]string, 0, len(entries))
+	for _, e := range entries {
+		if e.IsDir() {
+			continue
+		}
+		ext := filepath.Ext(e.Name())
+		if ext != ".json" {
+			continue
+		}
+		names = append(names, e.Name()[:len(e.Name())-len(ext)])
+	}
+	sort.Strings(names)
+	return names, nil
+}
+
+func printSessions() error {
+	names, err := listSessions()
+	if err != nil {
+		return err
+	}
+	if len(names) == 0 {
+		fmt.Println("No saved sessions.")
+		return nil
+	}
+	for _, name := range names {
+		fmt.Println(name)
+	}
+	return nil
+}
@@ -0,0 +1,126 @@
+package main
+
+import (
+	"bytes"
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+	"text/template"
+
+	"gopkg.in/yaml.v2"
+)
+
+// Template is a reusable, checked-in prompt, loaded from a YAML file under
+// the templates/ directory (or one of the built-ins below).
+type Template struct {
+	System string   `yaml:"system"`
+	User   string   `yaml:"user"`
+	Params []string `yaml:"params"`
+}
+
+// builtinTemplates ships a handful of common workflows so `lqy -t <name>`
+// works out of the box, without requiring ~/.lqy/templates/<name>.yaml.
+var builtinTemplates = map[string]Template{
+	"explain-cmd": {
+		System: "You are a terminal expert who explains shell commands clearly and concisely.",
+		User:   "Explain what this command does, flag by flag:\n\n{{ .cmd }}",
+		Params: []string{"cmd"},
+	},
+	"summarize": {
+		System: "You summarize text accurately and concisely.",
+		User:   "Summarize the following:\n\n{{ .stdin }}",
+		Params: []string{},
+	},
+	"commit-message": {
+		System: "You write concise, conventional git commit messages from a diff.",
+		User:   "Write a commit message for this diff:\n\n{{ .stdin }}",
+		Params: []string{},
+	},
+	"code-review": {
+		System: "You are an experienced code reviewer. Point out bugs, risks, and style issues.",
+		User:   "Review this patch:\n\n{{ .stdin }}",
+		Params: []string{},
+	},
+}
+
+// templatesDir returns ~/.lqy/templates, creating it if necessary.
+func templatesDir() (string, error) {
+	dir := filepath.Join(os.Getenv("HOME"), ".lqy", "templates")
+	if err := os.MkdirAll(dir, 0o755); err != nil {
+		return "", err
+	}
+	return dir, nil
+}
+
+// loadTemplate loads the named template from ~/.lqy/templates/<name>.yaml,
+// falling back to a built-in template of the same name.
+func loadTemplate(name string) (*Template, error) {
+	dir, err := templatesDir()
+	if err != nil {
+		return nil, err
+	}
+
+	data, err := os.ReadFile(filepath.Join(dir, name+".yaml"))
+	if err == nil {
+		var t Template
+		if err := yaml.Unmarshal(data, &t); err != nil {
+			return nil, err
+		}
+		return &t, nil
+	}
+	if !os.IsNotExist(err) {
+		return nil, err
+	}
+
+	if t, ok := builtinTemplates[name]; ok {
+		return &t, nil
+	}
+	return nil, fmt.Errorf("no such template %q", name)
+}
+
+// render substitutes values (CLI params plus "stdin") into the template's
+// system and user fields.
+func (t *Template) render(values map[string]string) (system, user string, err error) {
+	system, err = renderString(t.System, values)
+	if err != nil {
+		return "", "", err
+	}
+	user, err = renderString(t.User, values)
+	if err != nil {
+		return "", "", err
+	}
+	return system, user, nil
+}
+
+func renderString(text string, values map[string]string) (string, error) {
+	tmpl, err := template.New("template").Parse(text)
+	if err != nil {
+		return "", err
+	}
+	var buf bytes.Buffer
+	if err := tmpl.Execute(&buf, values); err != nil {
+		return "", err
+	}
+	return buf.String(), nil
+}
+
+// templateValues builds the {{ .param }} substitution map: positional args
+// bound to t.Params in order, plus the always-available {{ .stdin }}. The
+// last declared param absorbs all remaining args (space-joined), matching
+// how the non-template path joins flag.Args(), so a param like "cmd" can
+// capture a whole multi-word command instead of just its first token.
+func templateValues(t *Template, args []string, stdinInput string) map[string]string {
+	values := map[string]string{"stdin": stdinInput}
+	for i, name := range t.Params {
+		if i >= len(args) {
+			break
+		}
+		if i == len(t.Params)-1 {
+			values[name] = strings.Join(args[i:], " ")
+		} else {
+			values[name] = args[i]
+		}
+	}
+	return values
+}
@@ -0,0 +1,48 @@
+package main
+
+import (
+	"strings"
+
+	"github.com/pkoukk/tiktoken-go"
+)
+
+// encodingForModel picks the BPE encoding tiktoken should use for a given
+// model name, mirroring OpenAI's own model->encoding table.
+func encodingForModel(model string) string {
+	switch {
+	case strings.HasPrefix(model, "gpt-4o"):
+		return "o200k_base"
+	case strings.HasPrefix(model, "gpt-4"), strings.HasPrefix(model, "gpt-3.5"):
+		return "cl100k_base"
+	default:
+		return "cl100k_base"
+	}
+}
+
+// countTokens returns the number of BPE tokens text encodes to under model's
+// encoding.
+func countTokens(text, model string) (int, error) {
+	enc, err := tiktoken.GetEncoding(encodingForModel(model))
+	if err != nil {
+		return 0, err
+	}
+	return len(enc.Encode(text, nil, nil)), nil
+}
+
+// truncateInput truncates input to at most maxTokens BPE tokens under
+// model's encoding, truncating on token boundaries rather than rune
+// boundaries.
+func truncateInput(input, model string, maxTokens int) (string, error) {
+	enc, err := tiktoken.GetEncoding(encodingForModel(model))
+	if err != nil {
+		return "", err
+	}
+
+	tokens := enc.Encode(input, nil, nil)
+	if len(tokens) <= maxTokens {
+		return input, nil
+	}
+
+	truncated := enc.Decode(tokens[:maxTokens])
+	return truncated + "\n...(input truncated due to length)", nil
+}
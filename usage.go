@@ -0,0 +1,155 @@
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"os"
+	"path/filepath"
+	"sort"
+	"time"
+)
+
+// ModelPrice is the USD cost per 1K tokens for a model, used to estimate
+// spend from a completion's reported usage.
+type ModelPrice struct {
+	PromptPer1K     float64 `yaml:"prompt_per_1k"`
+	CompletionPer1K float64 `yaml:"completion_per_1k"`
+}
+
+// defaultModelPrices seeds model_prices: with the list prices of common
+// OpenAI, Anthropic, and Zhipu models, in USD per 1K tokens.
+var defaultModelPrices = map[string]ModelPrice{
+	"gpt-4o":          {PromptPer1K: 0.005, CompletionPer1K: 0.015},
+	"gpt-4o-mini":     {PromptPer1K: 0.00015, CompletionPer1K: 0.0006},
+	"gpt-4-turbo":     {PromptPer1K: 0.01, CompletionPer1K: 0.03},
+	"gpt-4":           {PromptPer1K: 0.03, CompletionPer1K: 0.06},
+	"gpt-3.5-turbo":   {PromptPer1K: 0.0005, CompletionPer1K: 0.0015},
+	"claude-3-opus":   {PromptPer1K: 0.015, CompletionPer1K: 0.075},
+	"claude-3-sonnet": {PromptPer1K: 0.003, CompletionPer1K: 0.015},
+	"claude-3-haiku":  {PromptPer1K: 0.00025, CompletionPer1K: 0.00125},
+	"glm-4":           {PromptPer1K: 0.0014, CompletionPer1K: 0.0014},
+}
+
+// costFor estimates USD spend for usage under model, preferring an entry
+// from the user's configured prices and falling back to defaultModelPrices.
+func costFor(model string, prices map[string]ModelPrice, usage Usage) float64 {
+	price, ok := prices[model]
+	if !ok {
+		price, ok = defaultModelPrices[model]
+	}
+	if !ok {
+		return 0
+	}
+	return float64(usage.PromptTokens)/1000*price.PromptPer1K +
+		float64(usage.CompletionTokens)/1000*price.CompletionPer1K
+}
+
+// UsageRecord is one line of ~/.lqy/usage.jsonl.
+type UsageRecord struct {
+	Timestamp        time.Time `json:"timestamp"`
+	Model            string    `json:"model"`
+	PromptTokens     int       `json:"prompt_tokens"`
+	CompletionTokens int       `json:"completion_tokens"`
+	CostUSD          float64   `json:"cost_usd"`
+}
+
+func usageLogPath() (string, error) {
+	dir := filepath.Join(os.Getenv("HOME"), ".lqy")
+	if err := os.MkdirAll(dir, 0o755); err != nil {
+		return "", err
+	}
+	return filepath.Join(dir, "usage.jsonl"), nil
+}
+
+// recordUsage appends a UsageRecord to ~/.lqy/usage.jsonl.
+func recordUsage(rec UsageRecord) error {
+	path, err := usageLogPath()
+	if err != nil {
+		return err
+	}
+
+	f, err := os.OpenFile(path, os.O_APPEND|os.O_CREATE|os.O_WRONLY, 0o644)
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+
+	data, err := json.Marshal(rec)
+	if err != nil {
+		return err
+	}
+	_, err = f.Write(append(data, '\n'))
+	return err
+}
+
+func readUsageRecords() ([]UsageRecord, error) {
+	path, err := usageLogPath()
+	if err != nil {
+		return nil, err
+	}
+
+	data, err := os.ReadFile(path)
+	if os.IsNotExist(err) {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	var records []UsageRecord
+	dec := json.NewDecoder(bytes.NewReader(data))
+	for dec.More() {
+		var rec UsageRecord
+		if err := dec.Decode(&rec); err != nil {
+			return nil, err
+		}
+		records = append(records, rec)
+	}
+	return records, nil
+}
+
+// printUsageReport aggregates ~/.lqy/usage.jsonl by model and day and prints
+// a summary table to stdout.
+func printUsageReport() error {
+	records, err := readUsageRecords()
+	if err != nil {
+		return err
+	}
+	if len(records) == 0 {
+		fmt.Println("No usage recorded yet.")
+		return nil
+	}
+
+	type key struct {
+		day   string
+		model string
+	}
+	totals := map[key]float64{}
+	for _, rec := range records {
+		k := key{day: rec.Timestamp.Format("2006-01-02"), model: rec.Model}
+		totals[k] += rec.CostUSD
+	}
+
+	keys := make([]key, 0, len(totals))
+	for k := range totals {
+		keys = append(keys, k)
+	}
+	sort.Slice(keys, func(i, j int) bool {
+		if keys[i].day != keys[j].day {
+			return keys[i].day < keys[j].day
+		}
+		return keys[i].model < keys[j].model
+	})
+
+	fmt.Printf("%-12s %-20s %s\n", "DATE", "MODEL", "COST (USD)")
+	for _, k := range keys {
+		fmt.Printf("%-12s %-20s $%.4f\n", k.day, k.model, totals[k])
+	}
+	return nil
+}
+
+func showUsageSummary(model string, usage Usage, cost float64) {
+	fmt.Fprintf(os.Stderr, "usage: model=%s prompt_tokens=%d completion_tokens=%d cost=$%.4f\n",
+		model, usage.PromptTokens, usage.CompletionTokens, cost)
+}
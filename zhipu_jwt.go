@@ -0,0 +1,48 @@
+package main
+
+import (
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/json"
+	"time"
+)
+
+// signZhipuToken builds the short-lived HS256 JWT Zhipu's API uses in place
+// of a static bearer token, as described at open.bigmodel.cn's auth docs.
+func signZhipuToken(apiID, apiSecret string) (string, error) {
+	header := map[string]interface{}{
+		"alg":       "HS256",
+		"sign_type": "SIGN",
+	}
+	now := time.Now()
+	payload := map[string]interface{}{
+		"api_key":   apiID,
+		"exp":       now.Add(5 * time.Minute).UnixMilli(),
+		"timestamp": now.UnixMilli(),
+	}
+
+	headerB64, err := base64URLEncodeJSON(header)
+	if err != nil {
+		return "", err
+	}
+	payloadB64, err := base64URLEncodeJSON(payload)
+	if err != nil {
+		return "", err
+	}
+
+	signingInput := headerB64 + "." + payloadB64
+	mac := hmac.New(sha256.New, []byte(apiSecret))
+	mac.Write([]byte(signingInput))
+	sig := base64.RawURLEncoding.EncodeToString(mac.Sum(nil))
+
+	return signingInput + "." + sig, nil
+}
+
+func base64URLEncodeJSON(v interface{}) (string, error) {
+	data, err := json.Marshal(v)
+	if err != nil {
+		return "", err
+	}
+	return base64.RawURLEncoding.EncodeToString(data), nil
+}